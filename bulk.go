@@ -0,0 +1,184 @@
+package esdump
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sethgrid/pester"
+)
+
+// DefaultBulkSize is the byte size threshold at which BulkWriter flushes an
+// accumulated batch to Target, unless overridden.
+const DefaultBulkSize = 5 * 1024 * 1024
+
+// DefaultBulkDocs is the document count threshold at which BulkWriter
+// flushes an accumulated batch to Target, unless overridden.
+const DefaultBulkDocs = 1000
+
+// bulkActionLine is the "action and meta data" line preceding each document
+// in the bulk API, https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-bulk.html.
+type bulkActionLine struct {
+	Index struct {
+		Index string `json:"_index"`
+		ID    string `json:"_id,omitempty"`
+	} `json:"index"`
+}
+
+// BulkWriter turns hits into Elasticsearch bulk-API NDJSON: one action line
+// followed by the document source, per hit. With Target unset, it writes
+// the NDJSON straight to Writer, turning a scroll dump into something that
+// can be replayed with `curl -XPOST .../_bulk --data-binary @dump.ndjson`.
+// With Target set, it instead batches documents (flushing every BulkSize
+// bytes or BulkDocs documents, whichever comes first) and POSTs each batch
+// directly to Target's /_bulk endpoint, retrying on 429 with exponential
+// backoff -- this turns esdump into a reindex/migration tool.
+type BulkWriter struct {
+	Index    string // overrides the destination index; empty uses each hit's own _index
+	Writer   io.Writer
+	Target   string // elasticsearch server to POST batches to, e.g. https://other-cluster:9200
+	BulkSize int    // bytes per batch before flushing to Target, defaults to DefaultBulkSize
+	BulkDocs int    // docs per batch before flushing to Target, defaults to DefaultBulkDocs
+	Auth     AuthConfig
+
+	buf    bytes.Buffer
+	docs   int
+	client *pester.Client
+}
+
+// httpClient lazily builds the authenticated client used to POST batches.
+func (b *BulkWriter) httpClient() (*pester.Client, error) {
+	client, err := b.Auth.Client(b.client)
+	if err != nil {
+		return nil, err
+	}
+	b.client = client
+	return client, nil
+}
+
+func (b *BulkWriter) bulkSize() int {
+	if b.BulkSize > 0 {
+		return b.BulkSize
+	}
+	return DefaultBulkSize
+}
+
+func (b *BulkWriter) bulkDocs() int {
+	if b.BulkDocs > 0 {
+		return b.BulkDocs
+	}
+	return DefaultBulkDocs
+}
+
+// WriteHit appends a single document to the current batch, flushing it
+// (to Writer, or to Target) once full, or immediately if Target is unset.
+func (b *BulkWriter) WriteHit(index, id string, source json.RawMessage) error {
+	var action bulkActionLine
+	if b.Index != "" {
+		action.Index.Index = b.Index
+	} else {
+		action.Index.Index = index
+	}
+	action.Index.ID = id
+	header, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+	b.buf.Write(header)
+	b.buf.WriteByte('\n')
+	b.buf.Write(source)
+	b.buf.WriteByte('\n')
+	b.docs++
+	if b.Target == "" {
+		return b.flushTo(b.Writer)
+	}
+	if b.buf.Len() >= b.bulkSize() || b.docs >= b.bulkDocs() {
+		return b.Flush()
+	}
+	return nil
+}
+
+// WritePage parses a single scroll/PIT response page and writes each of its
+// hits through WriteHit.
+func (b *BulkWriter) WritePage(page []byte) error {
+	var sr SearchResponse
+	if err := json.Unmarshal(page, &sr); err != nil {
+		return err
+	}
+	for _, hit := range sr.Hits.Hits {
+		if err := b.WriteHit(hit.Index, hit.Id, hit.Source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushTo drains the current batch to w, resetting the batch afterwards.
+func (b *BulkWriter) flushTo(w io.Writer) error {
+	if b.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.Write(b.buf.Bytes())
+	b.buf.Reset()
+	b.docs = 0
+	return err
+}
+
+// Flush sends the current batch to Target, retrying on 429 (too many
+// requests) with exponential backoff. If Target is unset, it drains to
+// Writer instead. Call Flush once more after the last WriteHit to send any
+// partial, not yet full, batch.
+func (b *BulkWriter) Flush() error {
+	if b.Target == "" {
+		return b.flushTo(b.Writer)
+	}
+	if b.buf.Len() == 0 {
+		return nil
+	}
+	client, err := b.httpClient()
+	if err != nil {
+		return err
+	}
+	body := make([]byte, b.buf.Len())
+	copy(body, b.buf.Bytes())
+	link := fmt.Sprintf("%s/_bulk", b.Target)
+	var sleep = 1 * time.Second
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest("POST", link, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			resp.Body.Close()
+			if attempt >= 8 {
+				return fmt.Errorf("_bulk: too many 429s, giving up")
+			}
+			log.Printf("_bulk: 429, retrying in %s", sleep)
+			time.Sleep(sleep)
+			sleep *= 2
+			continue
+		}
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("_bulk: unexpected status %s", resp.Status)
+		}
+		break
+	}
+	b.buf.Reset()
+	b.docs = 0
+	return nil
+}
@@ -3,7 +3,6 @@
 // be more generic. It uses HTTP GET only.
 //
 // $ esdump -s https://search.fatcat.wiki -i fatcat_release -q 'web archiving'
-//
 package main
 
 import (
@@ -18,6 +17,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/miku/esdump"
 	"github.com/miku/esdump/stringutil"
@@ -26,16 +26,37 @@ import (
 )
 
 var (
-	query       = flag.String("q", "*", `lucene syntax query to run, example: 'affiliation:"alberta"'`)
-	index       = flag.String("i", "fatcat_release", "index name")
-	server      = flag.String("s", "https://search.fatcat.wiki", "elasticsearch server")
-	scroll      = flag.String("scroll", "5m", "context timeout")
-	size        = flag.Int("size", 1000, "batch size")
-	verbose     = flag.Bool("verbose", false, "be verbose")
-	showVersion = flag.Bool("v", false, "show version")
-	idsFile     = flag.String("ids", "", "a path to a file with one id per line to fetch")
-	massQuery   = flag.String("mq", "", "path to file, one lucene query per line")
-	limit       = flag.Int("l", 0, "limit number of documents fetched, zero means no limit")
+	query             = flag.String("q", "*", `lucene syntax query to run, example: 'affiliation:"alberta"'`)
+	index             = flag.String("i", "fatcat_release", "index name")
+	server            = flag.String("s", "https://search.fatcat.wiki", "elasticsearch server")
+	scroll            = flag.String("scroll", "5m", "context timeout")
+	size              = flag.Int("size", 1000, "batch size")
+	verbose           = flag.Bool("verbose", false, "be verbose")
+	showVersion       = flag.Bool("v", false, "show version")
+	idsFile           = flag.String("ids", "", "a path to a file with one id per line to fetch")
+	massQuery         = flag.String("mq", "", "path to file, one lucene query per line")
+	limit             = flag.Int("l", 0, "limit number of documents fetched, zero means no limit")
+	mode              = flag.String("mode", "scroll", "pagination mode: scroll or pit")
+	slices            = flag.Int("slices", 0, "run scroll in N parallel slices, zero disables slicing")
+	apiKey            = flag.String("api-key", "", "elasticsearch API key (or set ELASTICSEARCH_API_KEY)")
+	userPass          = flag.String("u", "", "basic auth credentials, user:pass (or set ELASTIC_PASSWORD, with -u user)")
+	bearerToken       = flag.String("bearer", "", "bearer token for authentication")
+	caCertFile        = flag.String("cacert", "", "path to a PEM encoded CA certificate")
+	insecure          = flag.Bool("k", false, "disable TLS certificate verification")
+	output            = flag.String("o", "raw", "output mode: raw, bulk, or bulk-load")
+	outputIndex       = flag.String("o-index", "", "override destination index for bulk output modes")
+	target            = flag.String("target", "", "target elasticsearch server for -o bulk-load")
+	bulkSize          = flag.Int("bulk-size", esdump.DefaultBulkSize, "bytes per batch before flushing to -target")
+	bulkDocs          = flag.Int("bulk-docs", esdump.DefaultBulkDocs, "docs per batch before flushing to -target")
+	targetAPIKey      = flag.String("target-api-key", "", "API key for -target, if different from -api-key")
+	targetUserPass    = flag.String("target-u", "", "basic auth credentials for -target, user:pass, if different from -u")
+	targetBearerToken = flag.String("target-bearer", "", "bearer token for -target, if different from -bearer")
+	targetCACertFile  = flag.String("target-cacert", "", "path to a PEM encoded CA certificate for -target, if different from -cacert")
+	targetInsecure    = flag.Bool("target-k", false, "disable TLS certificate verification for -target")
+	filterPath        = flag.String("filter-path", "", "comma separated list of fields to keep in the response, e.g. 'hits.hits._id'")
+	sourceInclude     = flag.String("source-include", "", "comma separated list of _source fields to include")
+	sourceExclude     = flag.String("source-exclude", "", "comma separated list of _source fields to exclude")
+	trackTotalHits    = flag.String("track-total-hits", "", "track_total_hits value to send, e.g. 'false' or '10000'")
 
 	exampleUsage = `esdump uses the elasticsearch scroll API to stream
 documents to stdout. First written to extract samples from
@@ -50,9 +71,96 @@ discovery project).
 	Buildtime = ""
 )
 
+// authConfig builds an esdump.AuthConfig from flags, falling back to the
+// ELASTICSEARCH_API_KEY and ELASTIC_PASSWORD env vars so credentials don't
+// have to be passed on the command line.
+func authConfig() esdump.AuthConfig {
+	var (
+		username string
+		password string
+	)
+	switch {
+	case strings.Contains(*userPass, ":"):
+		parts := strings.SplitN(*userPass, ":", 2)
+		username, password = parts[0], parts[1]
+	case *userPass != "":
+		username, password = *userPass, os.Getenv("ELASTIC_PASSWORD")
+	}
+	key := *apiKey
+	if key == "" {
+		key = os.Getenv("ELASTICSEARCH_API_KEY")
+	}
+	return esdump.AuthConfig{
+		APIKey:             key,
+		Username:           username,
+		Password:           password,
+		BearerToken:        *bearerToken,
+		CACertFile:         *caCertFile,
+		InsecureSkipVerify: *insecure,
+	}
+}
+
+// targetAuthConfig builds the esdump.AuthConfig for -o bulk-load's -target
+// cluster. If none of -target-api-key, -target-u or -target-bearer is given,
+// the credentials of source (the cluster being scrolled) are reused; the CA
+// cert and -k fall back to source's on their own, since a migration commonly
+// reads from one cluster and writes to another with its own credentials
+// and/or CA cert.
+func targetAuthConfig(source esdump.AuthConfig) esdump.AuthConfig {
+	var username, password string
+	switch {
+	case strings.Contains(*targetUserPass, ":"):
+		parts := strings.SplitN(*targetUserPass, ":", 2)
+		username, password = parts[0], parts[1]
+	case *targetUserPass != "":
+		username, password = *targetUserPass, os.Getenv("ELASTIC_PASSWORD")
+	}
+	key, bearer := *targetAPIKey, *targetBearerToken
+	if key == "" && bearer == "" && *targetUserPass == "" {
+		key, bearer = source.APIKey, source.BearerToken
+		username, password = source.Username, source.Password
+	}
+	cacert := *targetCACertFile
+	if cacert == "" {
+		cacert = source.CACertFile
+	}
+	return esdump.AuthConfig{
+		APIKey:             key,
+		Username:           username,
+		Password:           password,
+		BearerToken:        bearer,
+		CACertFile:         cacert,
+		InsecureSkipVerify: *targetInsecure || source.InsecureSkipVerify,
+	}
+}
+
+// splitCSV splits a comma separated flag value into its fields, returning
+// nil for an empty string so the zero value round-trips cleanly.
+func splitCSV(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var out []string
+	for _, field := range strings.Split(s, ",") {
+		out = append(out, strings.TrimSpace(field))
+	}
+	return out
+}
+
+// scroller abstracts the pagination strategies (scroll API, point in time
+// API, ...) that fmt.Println can render a page from.
+type scroller interface {
+	fmt.Stringer
+	Next() bool
+	Bytes() []byte
+	Err() error
+	Total() int
+	Elapsed() time.Duration
+}
+
 // identifierDump reads each line (id) from r and will create batched ids
 // requests and will write the responses to the given writer.
-func identifierDump(r io.Reader, w io.Writer) error {
+func identifierDump(r io.Reader, w io.Writer, client *pester.Client) error {
 	var (
 		br    = bufio.NewReader(r)
 		batch []string
@@ -80,7 +188,7 @@ func identifierDump(r io.Reader, w io.Writer) error {
 		if err != nil {
 			return err
 		}
-		resp, err := pester.Do(req)
+		resp, err := client.Do(req)
 		if err != nil {
 			return err
 		}
@@ -161,6 +269,11 @@ func main() {
 	if !*verbose {
 		log.SetOutput(ioutil.Discard)
 	}
+	auth := authConfig()
+	client, err := esdump.NewClient(auth)
+	if err != nil {
+		log.Fatal(err)
+	}
 	switch {
 	case *idsFile != "":
 		var r io.Reader
@@ -180,7 +293,7 @@ func main() {
 		}
 		bw := bufio.NewWriter(os.Stdout)
 		defer bw.Flush()
-		if err := identifierDump(r, bw); err != nil {
+		if err := identifierDump(r, bw, client); err != nil {
 			log.Fatal(err)
 		}
 	case *massQuery != "":
@@ -207,27 +320,105 @@ func main() {
 			Index:   *index,
 			Queries: queries,
 			Size:    0,
+			Auth:    auth,
 			Writer:  os.Stdout,
 		}
 		if err := mq.Run(context.Background()); err != nil {
 			log.Fatal(err)
 		}
 		// TODO: Abtract various reading routines.
-	default:
+	case *slices > 0:
 		q, err := unifyQuery(*query)
 		if err != nil {
 			log.Fatal(err)
 		}
-		ss := &esdump.BasicScroller{
+		ss := &esdump.SlicedScroller{
 			Server: *server,
 			Size:   *size,
 			Index:  *index,
 			Query:  q,
 			Scroll: *scroll,
+			Slices: *slices,
+			Auth:   auth,
+			Writer: os.Stdout,
+		}
+		if err := ss.Run(context.Background()); err != nil {
+			log.Fatal(err)
+		}
+		if *verbose {
+			log.Printf("%d docs across %d slices", ss.Total(), *slices)
+		}
+	default:
+		q, err := unifyQuery(*query)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var (
+			ss       scroller
+			closePIT = func() {} // no-op unless -mode pit
+		)
+		switch *mode {
+		case "pit":
+			ps := &esdump.PITScroller{
+				Server: *server,
+				Size:   *size,
+				Index:  *index,
+				Query:  q,
+				Scroll: *scroll,
+				Auth:   auth,
+			}
+			closePIT = func() {
+				if err := ps.Close(); err != nil {
+					log.Printf("pit: close failed: %v", err)
+				}
+			}
+			defer closePIT()
+			ss = ps
+		case "scroll":
+			ss = &esdump.BasicScroller{
+				Server:         *server,
+				Size:           *size,
+				Index:          *index,
+				Query:          q,
+				Scroll:         *scroll,
+				Auth:           auth,
+				FilterPath:     *filterPath,
+				SourceIncludes: splitCSV(*sourceInclude),
+				SourceExcludes: splitCSV(*sourceExclude),
+				TrackTotalHits: *trackTotalHits,
+			}
+		default:
+			log.Fatalf("invalid mode: %s (want scroll or pit)", *mode)
+		}
+		var bw *esdump.BulkWriter
+		switch *output {
+		case "raw":
+		case "bulk":
+			bw = &esdump.BulkWriter{Index: *outputIndex, Writer: os.Stdout}
+		case "bulk-load":
+			if *target == "" {
+				log.Fatal("-o bulk-load requires -target")
+			}
+			bw = &esdump.BulkWriter{
+				Index:    *outputIndex,
+				Target:   *target,
+				BulkSize: *bulkSize,
+				BulkDocs: *bulkDocs,
+				Auth:     targetAuthConfig(auth),
+			}
+		default:
+			log.Fatalf("invalid output mode: %s (want raw, bulk, or bulk-load)", *output)
 		}
 		var i int
 		for ss.Next() {
-			fmt.Println(ss)
+			if bw != nil {
+				if err := bw.WritePage(ss.Bytes()); err != nil {
+					closePIT()
+					log.Fatal(err)
+				}
+			} else {
+				fmt.Println(ss)
+			}
 			i += *size
 			if *limit > 0 && i >= *limit {
 				if *verbose {
@@ -236,7 +427,14 @@ func main() {
 				break
 			}
 		}
+		if bw != nil {
+			if err := bw.Flush(); err != nil {
+				closePIT()
+				log.Fatal(err)
+			}
+		}
 		if ss.Err() != nil {
+			closePIT()
 			log.Fatal(ss.Err())
 		}
 		if *verbose {
@@ -0,0 +1,292 @@
+package esdump
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/miku/esdump/stringutil"
+	"github.com/sethgrid/pester"
+)
+
+// pitSearchResponse is a search response as returned when querying with a
+// point in time id; the pit id travels in the body instead of the
+// "_scroll_id" field.
+type pitSearchResponse struct {
+	Hits struct {
+		Hits []struct {
+			Id     string          `json:"_id"`
+			Index  string          `json:"_index"`
+			Score  float64         `json:"_score"`
+			Source json.RawMessage `json:"_source"`
+			Sort   []interface{}   `json:"sort"`
+		} `json:"hits"`
+		MaxScore float64 `json:"max_score"`
+		Total    int64   `json:"total"`
+	} `json:"hits"`
+	PitID  string `json:"pit_id"`
+	Shards struct {
+		Failed     int64 `json:"failed"`
+		Skipped    int64 `json:"skipped"`
+		Successful int64 `json:"successful"`
+		Total      int64 `json:"total"`
+	} `json:"_shards"`
+	TimedOut bool  `json:"timed_out"`
+	Took     int64 `json:"took"`
+}
+
+// PITScroller paginates over a query using the point in time (PIT) API,
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/point-in-time-api.html,
+// available since ES 7.10. Unlike BasicScroller, it does not keep a cursor
+// open against a specific set of shards via the scroll API (which is
+// discouraged for long-running exports and disallowed on frozen or
+// searchable snapshot indices), but instead keeps a lightweight PIT id
+// that is refreshed on every page.
+type PITScroller struct {
+	Server     string // https://search.elastic.io
+	Index      string
+	Query      string // JSON search body with a top-level "query" key; a non-JSON string is run as a query_string query
+	Scroll     string // keep_alive, e.g. "5m"
+	Size       int    // number of docs per request
+	MaxRetries int    // retry of stranger things, like "unexpected EOF"
+	Auth       AuthConfig
+
+	pitID       string         // current point in time id, refreshed on every page
+	searchAfter []interface{}  // sort values of the last hit of the previous page, nil on first call
+	buf         bytes.Buffer   // buffer for response body
+	total       int            // docs already received
+	done        bool           // true once a short page has been observed
+	client      *pester.Client // lazily built from Auth on first use
+	err         error
+	started     time.Time
+}
+
+// httpClient lazily builds the authenticated client used for all requests.
+func (s *PITScroller) httpClient() (*pester.Client, error) {
+	client, err := s.Auth.Client(s.client)
+	if err != nil {
+		return nil, err
+	}
+	s.client = client
+	return client, nil
+}
+
+// openPIT requests a new point in time id for the configured index.
+func (s *PITScroller) openPIT() (id string, err error) {
+	var (
+		link = fmt.Sprintf(`%s/%s/_pit?keep_alive=%s`, s.Server, s.Index, s.Scroll)
+		req  *http.Request
+		resp *http.Response
+		v    struct {
+			ID string `json:"id"`
+		}
+	)
+	log.Printf("pit: open %s", link)
+	req, err = http.NewRequest("POST", link, nil)
+	if err != nil {
+		return
+	}
+	client, err := s.httpClient()
+	if err != nil {
+		return
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return
+	}
+	log.Printf("pit: opened %s", stringutil.Trim(v.ID, 25, "..."))
+	return v.ID, nil
+}
+
+// closePIT deletes the given point in time id.
+func (s *PITScroller) closePIT(id string) error {
+	var (
+		link = fmt.Sprintf("%s/_pit", s.Server)
+		buf  bytes.Buffer
+		v    = struct {
+			ID string `json:"id"`
+		}{ID: id}
+	)
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("DELETE", link, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client, err := s.httpClient()
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	return err
+}
+
+// searchBody assembles the request body for a single page, using pit and
+// search_after instead of an index name and a scroll id. Like BasicScroller's
+// requestBody and SlicedScroller's sliceQuery, Query is expected to be a full
+// search body with a top-level "query" key; only that inner query is carried
+// over, the rest (pit, sort, size, search_after) is added fresh.
+func (s *PITScroller) searchBody() ([]byte, error) {
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(s.Query), &body); err != nil {
+		// Not a JSON body, treat it as a query_string query.
+		body = map[string]interface{}{
+			"query": map[string]interface{}{
+				"query_string": map[string]interface{}{
+					"query": s.Query,
+				},
+			},
+		}
+	}
+	query, ok := body["query"]
+	if !ok {
+		query = body
+	}
+	payload := map[string]interface{}{
+		"query": query,
+		"pit": map[string]interface{}{
+			"id":         s.pitID,
+			"keep_alive": s.Scroll,
+		},
+		"sort":             []interface{}{map[string]interface{}{"_shard_doc": "asc"}},
+		"size":             s.Size,
+		"track_total_hits": false,
+	}
+	if s.searchAfter != nil {
+		payload["search_after"] = s.searchAfter
+	}
+	return json.Marshal(payload)
+}
+
+// Next fetches the next batch, which is accessible via Bytes or String
+// methods. Returns true, if successful, false if stream ended or an error
+// occured. The error can be accessed separately.
+func (s *PITScroller) Next() bool {
+	if s.err != nil || s.done {
+		return false
+	}
+	if s.started.IsZero() {
+		s.started = time.Now()
+	}
+	if s.pitID == "" {
+		s.pitID, s.err = s.openPIT()
+		if s.err != nil {
+			return false
+		}
+	}
+	var (
+		retry = -3
+		sleep = 10 * time.Second
+		sr    pitSearchResponse
+		link  = fmt.Sprintf("%s/_search", s.Server)
+	)
+	for {
+		if retry == s.MaxRetries {
+			s.err = fmt.Errorf("max retries exceeded")
+			return false
+		}
+		body, err := s.searchBody()
+		if err != nil {
+			s.err = err
+			return false
+		}
+		req, err := http.NewRequest("POST", link, bytes.NewReader(body))
+		if err != nil {
+			s.err = err
+			return false
+		}
+		req.Header.Set("Content-Type", "application/json")
+		log.Printf("%s [%d] [...]", req.URL, len(body))
+		client, err := s.httpClient()
+		if err != nil {
+			s.err = err
+			return false
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			s.err = err
+			return false
+		}
+		defer resp.Body.Close()
+		s.buf.Reset()
+		_, err = io.Copy(&s.buf, resp.Body) // we get an occasional "unexpected EOF" here, but why?
+		if err == nil {
+			break
+		}
+		s.err = err
+		log.Printf("body was: %s", stringutil.Trim(s.buf.String(), 1024, fmt.Sprintf("... (%d)", s.buf.Len())))
+		log.Printf("failed to copy response body: %v (%s)", s.err, link)
+		log.Printf("retrying in %s", sleep)
+		time.Sleep(sleep)
+		retry++
+		s.err = nil
+	}
+	if s.err = json.Unmarshal(s.buf.Bytes(), &sr); s.err != nil {
+		return false
+	}
+	if sr.PitID != "" {
+		s.pitID = sr.PitID
+	}
+	n := len(sr.Hits.Hits)
+	s.total += n
+	log.Printf("fetched=%d, received=%d", s.total, s.buf.Len())
+	if n > 0 {
+		s.searchAfter = sr.Hits.Hits[n-1].Sort
+	}
+	if n < s.Size {
+		s.done = true
+	}
+	return n > 0
+}
+
+// Bytes returns the current response body.
+func (s *PITScroller) Bytes() []byte {
+	return s.buf.Bytes()
+}
+
+// String returns current response body as string.
+func (s *PITScroller) String() string {
+	return s.buf.String()
+}
+
+// Err returns any error.
+func (s *PITScroller) Err() error {
+	return s.err
+}
+
+// Elapsed returns the elapsed time.
+func (s *PITScroller) Elapsed() time.Duration {
+	return time.Since(s.started)
+}
+
+// Total returns total documents retrieved.
+func (s *PITScroller) Total() int {
+	return s.total
+}
+
+// Close deletes the point in time, releasing its resources on the server.
+// Should be called once the scroller is no longer used.
+func (s *PITScroller) Close() error {
+	if s.pitID == "" {
+		return nil
+	}
+	id := s.pitID
+	s.pitID = ""
+	return s.closePIT(id)
+}
@@ -7,6 +7,8 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -49,30 +51,113 @@ type BasicScroller struct {
 	Scroll     string // context timeout, e.g. "5m"
 	Size       int    // number of docs per request
 	MaxRetries int    // Retry of stranger things, like "unexpected EOF"
+	Auth       AuthConfig
 
-	id      string       // will be determined by first request, might change during the scroll
-	buf     bytes.Buffer // buffer for response body
-	total   int          // docs already received
+	FilterPath     string   // restrict the response to these fields, comma separated, e.g. "hits.hits._id"
+	SourceIncludes []string // only return these _source fields
+	SourceExcludes []string // exclude these _source fields
+	TrackTotalHits string   // "true", "false" or a number as string; empty leaves the cluster default
+
+	id      string         // will be determined by first request, might change during the scroll
+	buf     bytes.Buffer   // buffer for response body
+	total   int            // docs already received
+	client  *pester.Client // lazily built from Auth on first use
 	err     error
 	started time.Time
 }
 
+// httpClient lazily builds the authenticated client used for all requests.
+func (s *BasicScroller) httpClient() (*pester.Client, error) {
+	client, err := s.Auth.Client(s.client)
+	if err != nil {
+		return nil, err
+	}
+	s.client = client
+	return client, nil
+}
+
+// requestBody merges _source and track_total_hits shaping options into the
+// query, if any were set; otherwise it returns Query unchanged.
+func (s *BasicScroller) requestBody() (string, error) {
+	if len(s.SourceIncludes) == 0 && len(s.SourceExcludes) == 0 && s.TrackTotalHits == "" {
+		return s.Query, nil
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(s.Query), &body); err != nil {
+		return "", fmt.Errorf("query must be JSON to apply response shaping: %w", err)
+	}
+	if len(s.SourceIncludes) > 0 || len(s.SourceExcludes) > 0 {
+		source := make(map[string]interface{})
+		if len(s.SourceIncludes) > 0 {
+			source["includes"] = s.SourceIncludes
+		}
+		if len(s.SourceExcludes) > 0 {
+			source["excludes"] = s.SourceExcludes
+		}
+		body["_source"] = source
+	}
+	if s.TrackTotalHits != "" {
+		switch s.TrackTotalHits {
+		case "true":
+			body["track_total_hits"] = true
+		case "false":
+			body["track_total_hits"] = false
+		default:
+			if n, err := strconv.Atoi(s.TrackTotalHits); err == nil {
+				body["track_total_hits"] = n
+			} else {
+				body["track_total_hits"] = s.TrackTotalHits
+			}
+		}
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// withFilterPath appends a filter_path query parameter to link, if set.
+// _scroll_id is always kept, since the next page cannot be fetched without it.
+func (s *BasicScroller) withFilterPath(link string) string {
+	if s.FilterPath == "" {
+		return link
+	}
+	fp := s.FilterPath
+	if !strings.Contains(fp, "_scroll_id") {
+		fp += ",_scroll_id"
+	}
+	sep := "?"
+	if strings.Contains(link, "?") {
+		sep = "&"
+	}
+	return link + sep + "filter_path=" + url.QueryEscape(fp)
+}
+
 // initialRequest returns a scroll identifier for a given index and query.
 func (s *BasicScroller) initialRequest() (id string, err error) {
 	s.started = time.Now()
 	var (
-		link = fmt.Sprintf(`%s/%s/_search?scroll=%s&size=%d`, s.Server, s.Index, s.Scroll, s.Size)
+		link = s.withFilterPath(fmt.Sprintf(`%s/%s/_search?scroll=%s&size=%d`, s.Server, s.Index, s.Scroll, s.Size))
 		req  *http.Request
 		resp *http.Response
 		sr   SearchResponse
 	)
 	log.Printf("init: %s", link)
-	req, err = http.NewRequest("GET", link, strings.NewReader(s.Query))
+	body, err := s.requestBody()
+	if err != nil {
+		return
+	}
+	req, err = http.NewRequest("GET", link, strings.NewReader(body))
 	if err != nil {
 		return
 	}
 	req.Header.Set("Content-Type", "application/json")
-	resp, err = pester.Do(req)
+	client, err := s.httpClient()
+	if err != nil {
+		return
+	}
+	resp, err = client.Do(req)
 	if err != nil {
 		return
 	}
@@ -118,7 +203,7 @@ func (s *BasicScroller) Next() bool {
 				Scroll:   s.Scroll,
 				ScrollID: s.id,
 			}
-			link = fmt.Sprintf("%s/_search/scroll", s.Server)
+			link = s.withFilterPath(fmt.Sprintf("%s/_search/scroll", s.Server))
 			buf  bytes.Buffer
 			req  *http.Request
 			resp *http.Response
@@ -133,7 +218,12 @@ func (s *BasicScroller) Next() bool {
 		}
 		req.Header.Add("Content-Type", "application/json")
 		log.Printf("%s [%d] [...]", req.URL, buf.Len())
-		resp, s.err = pester.Do(req)
+		client, err := s.httpClient()
+		if err != nil {
+			s.err = err
+			return false
+		}
+		resp, s.err = client.Do(req)
 		if s.err != nil {
 			return false
 		}
@@ -154,13 +244,22 @@ func (s *BasicScroller) Next() bool {
 	}
 	s.id = sr.ScrollID
 	s.total += len(sr.Hits.Hits)
-	log.Printf("fetched=%d/%d (%0.2f%%), received=%d",
-		s.total, sr.Hits.Total, float64(s.total)/float64(sr.Hits.Total)*100, s.buf.Len())
+	if sr.Hits.Total > 0 {
+		log.Printf("fetched=%d/%d (%0.2f%%), received=%d",
+			s.total, sr.Hits.Total, float64(s.total)/float64(sr.Hits.Total)*100, s.buf.Len())
+	} else {
+		// track_total_hits was disabled (or the cluster omitted it), so there
+		// is nothing sensible to divide by.
+		log.Printf("fetched=%d, received=%d", s.total, s.buf.Len())
+	}
 	log.Println(stringutil.Shorten(s.id, 40))
-	if len(sr.Hits.Hits) == 0 && int64(s.total) != sr.Hits.Total {
+	if sr.Hits.Total > 0 && len(sr.Hits.Hits) == 0 && int64(s.total) != sr.Hits.Total {
 		log.Printf("warn: partial result")
 	}
-	return len(sr.Hits.Hits) > 0 && int64(s.total) <= sr.Hits.Total
+	if sr.Hits.Total > 0 {
+		return len(sr.Hits.Hits) > 0 && int64(s.total) <= sr.Hits.Total
+	}
+	return len(sr.Hits.Hits) > 0
 }
 
 // Bytes returns the current response body.
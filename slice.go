@@ -0,0 +1,128 @@
+package esdump
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// SlicedScroller partitions a scroll into a fixed number of independent
+// slices, https://www.elastic.co/guide/en/elasticsearch/reference/current/paginate-search-results.html#slice-scroll,
+// and runs them concurrently, each slice driven by its own BasicScroller.
+// This speeds up dumps of multi-million-doc indices, where a single scroll
+// is CPU-bound on one shard coordinator.
+type SlicedScroller struct {
+	Server      string // https://search.elastic.io
+	Index       string
+	Query       string // JSON search body, e.g. {"query": {...}}; a slice clause is added to it per worker
+	Scroll      string // context timeout, e.g. "5m"
+	Size        int    // number of docs per request, per slice
+	Slices      int    // number of slices to partition the scroll into
+	Concurrency int    // max number of slices running at once, defaults to Slices
+	MaxRetries  int    // passed through to each slice's BasicScroller
+	Auth        AuthConfig
+	Writer      io.Writer
+
+	total int64 // docs received, summed over the slices that finished without error
+	err   error
+	mu    sync.Mutex // serializes writes to Writer
+}
+
+// sliceQuery injects a slice clause into a query body, so each worker only
+// sees its own partition of the index.
+func sliceQuery(query string, id, max int) (string, error) {
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(query), &body); err != nil {
+		return "", fmt.Errorf("slice query must be JSON: %w", err)
+	}
+	body["slice"] = map[string]interface{}{
+		"id":  id,
+		"max": max,
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// runSlice drains a single slice's scroller, writing each page to the
+// shared writer.
+func (s *SlicedScroller) runSlice(id int) error {
+	q, err := sliceQuery(s.Query, id, s.Slices)
+	if err != nil {
+		return err
+	}
+	ss := &BasicScroller{
+		Server:     s.Server,
+		Index:      s.Index,
+		Query:      q,
+		Scroll:     s.Scroll,
+		Size:       s.Size,
+		MaxRetries: s.MaxRetries,
+		Auth:       s.Auth,
+	}
+	for ss.Next() {
+		s.mu.Lock()
+		_, werr := io.WriteString(s.Writer, ss.String())
+		if werr == nil {
+			_, werr = io.WriteString(s.Writer, "\n")
+		}
+		s.mu.Unlock()
+		if werr != nil {
+			return werr
+		}
+		log.Printf("slice %d/%d: fetched=%d", id, s.Slices, ss.Total())
+	}
+	if ss.Err() != nil {
+		return fmt.Errorf("slice %d/%d: %w", id, s.Slices, ss.Err())
+	}
+	atomic.AddInt64(&s.total, int64(ss.Total()))
+	return nil
+}
+
+// Run starts all slices and blocks until every slice has finished, then
+// returns the first error any slice reported. A failing slice does not stop
+// the others, and slices not yet started are still started.
+func (s *SlicedScroller) Run(ctx context.Context) error {
+	if s.Slices < 1 {
+		s.Slices = 1
+	}
+	if s.Concurrency < 1 {
+		s.Concurrency = s.Slices
+	}
+	// Bounded worker pool, so a large -slices doesn't open more connections
+	// than the cluster (or this process) can comfortably handle.
+	sem := make(chan struct{}, s.Concurrency)
+	g, _ := errgroup.WithContext(ctx)
+	for i := 0; i < s.Slices; i++ {
+		i := i
+		sem <- struct{}{}
+		g.Go(func() error {
+			defer func() { <-sem }()
+			return s.runSlice(i)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		s.err = err
+		return err
+	}
+	return nil
+}
+
+// Err returns any error encountered by a slice.
+func (s *SlicedScroller) Err() error {
+	return s.err
+}
+
+// Total returns total documents retrieved, summed across all slices that
+// finished without error.
+func (s *SlicedScroller) Total() int64 {
+	return atomic.LoadInt64(&s.total)
+}